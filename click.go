@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Click is one recorded hit against a shortlink.
+type Click struct {
+	Path       string    `json:"path"`
+	URL        string    `json:"url"`
+	Timestamp  time.Time `json:"timestamp"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent"`
+	Referer    string    `json:"referer"`
+}
+
+// ClickSink receives every recorded Click. Implementations should return
+// quickly; ClickTracker already delivers clicks off the request path via
+// its own buffered queue, so a sink should only block on its own I/O, not
+// add further buffering of its own.
+type ClickSink interface {
+	Record(click Click)
+}
+
+// StdoutSink writes each Click as a line of JSON to stdout.
+type StdoutSink struct{}
+
+// NewStdoutSink returns a ClickSink that logs clicks as JSONL to stdout.
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{}
+}
+
+func (s *StdoutSink) Record(click Click) {
+	json.NewEncoder(os.Stdout).Encode(click)
+}
+
+// SQLiteSink persists clicks to a "clicks" table via database/sql.
+type SQLiteSink struct {
+	db *sql.DB
+}
+
+// NewSQLiteSink creates the clicks table if it doesn't exist and returns
+// a ClickSink backed by db.
+func NewSQLiteSink(db *sql.DB) (*SQLiteSink, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS clicks (
+		path        TEXT NOT NULL,
+		url         TEXT NOT NULL,
+		timestamp   DATETIME NOT NULL,
+		remote_addr TEXT,
+		user_agent  TEXT,
+		referer     TEXT
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteSink{db: db}, nil
+}
+
+func (s *SQLiteSink) Record(click Click) {
+	s.db.Exec(`INSERT INTO clicks (path, url, timestamp, remote_addr, user_agent, referer)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		click.Path, click.URL, click.Timestamp, click.RemoteAddr, click.UserAgent, click.Referer)
+}
+
+// PrometheusSink exposes click counts as a Prometheus counter vector
+// labeled by path, for scraping via promhttp.
+type PrometheusSink struct {
+	counter *prometheus.CounterVec
+}
+
+// NewPrometheusSink registers (or reuses) a urlshort_clicks_total counter
+// vector on reg and returns a ClickSink backed by it.
+func NewPrometheusSink(reg prometheus.Registerer) *PrometheusSink {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "urlshort_clicks_total",
+		Help: "Total number of redirects served per path.",
+	}, []string{"path"})
+	reg.MustRegister(counter)
+	return &PrometheusSink{counter: counter}
+}
+
+func (s *PrometheusSink) Record(click Click) {
+	s.counter.WithLabelValues(click.Path).Inc()
+}
+
+// PathStats summarizes the clicks recorded for a single path.
+type PathStats struct {
+	Count   int       `json:"count"`
+	LastHit time.Time `json:"last_hit"`
+}
+
+// ClickTracker wraps a redirect handler to record every hit: the path
+// requested, the URL it resolved to, and request metadata. Recording is
+// asynchronous (an internal buffered channel feeds a background goroutine)
+// so a slow or blocking sink never delays the redirect response. An
+// in-memory per-path summary is always kept, independent of sink, and
+// served by Stats / the /admin/stats endpoint.
+type ClickTracker struct {
+	sink  ClickSink
+	queue chan Click
+	mu    sync.Mutex
+	stats map[string]*PathStats
+}
+
+// NewClickTracker starts a ClickTracker that forwards recorded clicks to
+// sink (which may be nil to only keep the in-memory summary) through a
+// channel buffered to size bufferSize.
+func NewClickTracker(sink ClickSink, bufferSize int) *ClickTracker {
+	t := &ClickTracker{
+		sink:  sink,
+		queue: make(chan Click, bufferSize),
+		stats: make(map[string]*PathStats),
+	}
+	go t.run()
+	return t
+}
+
+func (t *ClickTracker) run() {
+	for click := range t.queue {
+		t.record(click)
+	}
+}
+
+func (t *ClickTracker) record(click Click) {
+	t.mu.Lock()
+	ps, ok := t.stats[click.Path]
+	if !ok {
+		ps = &PathStats{}
+		t.stats[click.Path] = ps
+	}
+	ps.Count++
+	ps.LastHit = click.Timestamp
+	t.mu.Unlock()
+
+	if t.sink != nil {
+		t.sink.Record(click)
+	}
+}
+
+// Stats returns a snapshot of per-path click counts and last-hit times.
+func (t *ClickTracker) Stats() map[string]PathStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snapshot := make(map[string]PathStats, len(t.stats))
+	for path, ps := range t.stats {
+		snapshot[path] = *ps
+	}
+	return snapshot
+}
+
+// clickResponseWriter wraps an http.ResponseWriter to capture the
+// Location header set by a redirect, without altering the response seen
+// by the client.
+type clickResponseWriter struct {
+	http.ResponseWriter
+	location string
+}
+
+func (w *clickResponseWriter) WriteHeader(code int) {
+	w.location = w.Header().Get("Location")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Middleware wraps next so that every request handled by it (redirect or
+// not) is queued for recording. Recording never blocks the response: if
+// the internal buffer is full, the click is dropped rather than stalling
+// the redirect.
+func (t *ClickTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crw := &clickResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(crw, r)
+		if crw.location == "" {
+			return
+		}
+		click := Click{
+			Path:       r.URL.Path,
+			URL:        crw.location,
+			Timestamp:  time.Now(),
+			RemoteAddr: r.RemoteAddr,
+			UserAgent:  r.UserAgent(),
+			Referer:    r.Referer(),
+		}
+		select {
+		case t.queue <- click:
+		default:
+		}
+	})
+}
+
+// StatsHandler returns an http.HandlerFunc serving t.Stats() as JSON, for
+// mounting at /admin/stats.
+func (t *ClickTracker) StatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(t.Stats())
+	}
+}
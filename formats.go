@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v2"
+)
+
+// FormatDecoder unmarshals raw bytes in some format into a slice of
+// URLMapper. RegisterFormat lets callers plug in their own.
+type FormatDecoder func(data []byte, mappers *[]URLMapper) error
+
+// formatDecoders maps a file extension (including the leading dot) to the
+// decoder used to parse it. YAMLHandler/JSONHandler predate this registry
+// and keep their own standalone constructors, but FileHandler dispatches
+// through here.
+var formatDecoders = map[string]FormatDecoder{
+	".yml":  yamlDecode,
+	".yaml": yamlDecode,
+	".json": jsonDecode,
+	".hcl":  hclDecode,
+	".toml": tomlDecode,
+}
+
+// RegisterFormat adds or overrides the decoder used for files with the
+// given extension (e.g. ".ini"). It is not safe to call concurrently with
+// FileHandler.
+func RegisterFormat(ext string, decoder FormatDecoder) {
+	formatDecoders[ext] = decoder
+}
+
+func yamlDecode(data []byte, mappers *[]URLMapper) error {
+	return yaml.Unmarshal(data, mappers)
+}
+
+func jsonDecode(data []byte, mappers *[]URLMapper) error {
+	return json.Unmarshal(data, mappers)
+}
+
+// hclMapper mirrors URLMapper but with Expires as a string: hashicorp/hcl
+// can't decode directly into a time.Time field.
+type hclMapper struct {
+	Path    string            `hcl:"path"`
+	URL     string            `hcl:"url"`
+	Code    int               `hcl:"code"`
+	Expires string            `hcl:"expires"`
+	MaxHits int               `hcl:"max_hits"`
+	Headers map[string]string `hcl:"headers"`
+}
+
+func hclDecode(data []byte, mappers *[]URLMapper) error {
+	var doc struct {
+		Mappers []hclMapper `hcl:"mapper"`
+	}
+	if err := hcl.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	result := make([]URLMapper, len(doc.Mappers))
+	for i, m := range doc.Mappers {
+		result[i] = URLMapper{
+			Path:    m.Path,
+			URL:     m.URL,
+			Code:    m.Code,
+			MaxHits: m.MaxHits,
+			Headers: m.Headers,
+		}
+		if m.Expires != "" {
+			expires, err := time.Parse(time.RFC3339, m.Expires)
+			if err != nil {
+				return fmt.Errorf("urlshort: invalid expires %q for path %q: %w", m.Expires, m.Path, err)
+			}
+			result[i].Expires = expires
+		}
+	}
+	*mappers = result
+	return nil
+}
+
+func tomlDecode(data []byte, mappers *[]URLMapper) error {
+	var doc struct {
+		Mappers []URLMapper `toml:"mapper"`
+	}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	*mappers = doc.Mappers
+	return nil
+}
+
+// mapperHandler compiles mappers into a Router and returns an
+// http.HandlerFunc that redirects requests matching one of them, falling
+// back to fallback otherwise. It is the shared core behind YAMLHandler,
+// JSONHandler, HCLHandler, TOMLHandler and FileHandler, and replaces what
+// used to be a linear scan of mappers on every request with a single
+// trie lookup (see router.go).
+func mapperHandler(mappers []URLMapper, opts Options, fallback http.Handler) (http.HandlerFunc, error) {
+	return RouterHandler(mappers, opts, fallback)
+}
+
+// HCLHandler parses HCL in the same `path`/`url` shape as YAMLHandler,
+// expressed as repeated `mapper` blocks:
+//
+//	mapper {
+//	  path = "/some-path"
+//	  url  = "https://www.some-url.com/demo"
+//	}
+//
+// and returns an http.HandlerFunc that redirects matching paths, falling
+// back to fallback otherwise.
+func HCLHandler(HCL []byte, opts Options, fallback http.Handler) (http.HandlerFunc, error) {
+	var mappers []URLMapper
+	if err := hclDecode(HCL, &mappers); err != nil {
+		return nil, err
+	}
+	return mapperHandler(mappers, opts, fallback)
+}
+
+// TOMLHandler parses TOML expressed as repeated `[[mapper]]` tables and
+// returns an http.HandlerFunc that redirects matching paths, falling back
+// to fallback otherwise.
+func TOMLHandler(TOML []byte, opts Options, fallback http.Handler) (http.HandlerFunc, error) {
+	var mappers []URLMapper
+	if err := tomlDecode(TOML, &mappers); err != nil {
+		return nil, err
+	}
+	return mapperHandler(mappers, opts, fallback)
+}
+
+// FileHandler reads path and dispatches to the registered FormatDecoder
+// for its extension (.yml, .yaml, .json, .hcl, .toml, or anything added
+// via RegisterFormat), returning an http.HandlerFunc that redirects
+// matching paths and falls back to fallback otherwise.
+func FileHandler(path string, opts Options, fallback http.Handler) (http.HandlerFunc, error) {
+	decoder, ok := formatDecoders[filepath.Ext(path)]
+	if !ok {
+		return nil, fmt.Errorf("urlshort: no decoder registered for extension %q", filepath.Ext(path))
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mappers []URLMapper
+	if err := decoder(data, &mappers); err != nil {
+		return nil, err
+	}
+	return mapperHandler(mappers, opts, fallback)
+}
+
+// layeredHandler chains FileHandler-built handlers so that earlier
+// sources take precedence over later ones, with finalFallback served
+// when no source matches. format overrides extension-based dispatch for
+// any source whose extension isn't registered (e.g. "--format=toml" lets
+// an extensionless source be parsed as TOML); pass "" to rely solely on
+// extensions.
+func layeredHandler(sources []string, opts Options, format string, finalFallback http.Handler) (http.Handler, error) {
+	handler := finalFallback
+	for i := len(sources) - 1; i >= 0; i-- {
+		h, err := fileHandlerWithFormat(sources[i], opts, format, handler)
+		if err != nil {
+			return nil, err
+		}
+		handler = h
+	}
+	return handler, nil
+}
+
+// fileHandlerWithFormat is FileHandler, except that when path's extension
+// has no registered decoder it falls back to the decoder registered for
+// format (if any) instead of erroring.
+func fileHandlerWithFormat(path string, opts Options, format string, fallback http.Handler) (http.HandlerFunc, error) {
+	if _, ok := formatDecoders[filepath.Ext(path)]; ok || format == "" {
+		return FileHandler(path, opts, fallback)
+	}
+	decoder, ok := formatDecoders["."+format]
+	if !ok {
+		return nil, fmt.Errorf("urlshort: no decoder registered for format %q", format)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mappers []URLMapper
+	if err := decoder(data, &mappers); err != nil {
+		return nil, err
+	}
+	return mapperHandler(mappers, opts, fallback)
+}
@@ -1,24 +1,79 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/yaml.v2"
 )
 
 // Config is a struct describing the config parsed from cli arguments
 type Config struct {
-	PathToYAML string
-	PathToJSON string
+	PathToYAML  string
+	PathToJSON  string
+	Sources     stringSliceFlag
+	Format      string
+	Store       string
+	StorePath   string
+	ClickSink   string
+	ClickBuffer int
+	Watch       string
+	DefaultCode int
+}
+
+// stringSliceFlag implements flag.Value so --source can be repeated to
+// layer multiple mapping files, e.g. --source file.yaml --source file.json.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return fmt.Sprint([]string(*s))
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 type URLMapper struct {
-	Path string `yaml:"path" json:"path"`
-	URL  string `yaml:"url" json:"url"`
+	Path string `yaml:"path" json:"path" toml:"path"`
+	URL  string `yaml:"url" json:"url" toml:"url"`
+
+	// Code overrides Options.DefaultCode for this rule alone, e.g. 302 for
+	// a temporary link or 307/308 to preserve the request method.
+	Code int `yaml:"code,omitempty" json:"code,omitempty" toml:"code,omitempty"`
+	// Expires, once in the past, makes this rule stop matching and fall
+	// through to the fallback handler instead of redirecting.
+	Expires time.Time `yaml:"expires,omitempty" json:"expires,omitempty" toml:"expires,omitempty"`
+	// MaxHits, once reached, makes this rule stop matching and fall
+	// through to the fallback handler instead of redirecting.
+	MaxHits int `yaml:"max_hits,omitempty" json:"max_hits,omitempty" toml:"max_hits,omitempty"`
+	// Headers are set on the response before the redirect is written.
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty" toml:"headers,omitempty"`
+}
+
+// Options configures behavior shared across every rule handled by
+// YAMLHandler, JSONHandler, MapHandler and the handlers built on top of
+// them (HCLHandler, TOMLHandler, FileHandler, WatchingHandler, StoreHandler).
+type Options struct {
+	// DefaultCode is the redirect status used for a rule that doesn't set
+	// its own URLMapper.Code. Common shortener practice is 302 (temporary)
+	// rather than the permanent 301, so that a shortlink's target can be
+	// changed later without every client and proxy caching the old one.
+	DefaultCode int
+}
+
+// DefaultOptions returns the Options used when none are supplied:
+// DefaultCode 302 (http.StatusFound).
+func DefaultOptions() Options {
+	return Options{DefaultCode: http.StatusFound}
 }
 
 // YAMLHandler will parse the provided YAML and then return an http.HandlerFunc (which also implements http.Handler) that will attempt to map any paths to their corresponding
@@ -29,51 +84,41 @@ type URLMapper struct {
 //     - path: /some-path
 //       url: https://www.some-url.com/demo
 //
+// Paths may also contain ":param" and trailing "*catchall" segments
+// (e.g. "/gh/:user/:repo"), in which case url may reference the captured
+// values with Go text/template syntax (e.g. "https://github.com/{{.user}}/{{.repo}}").
+// Matching is done via a compiled router (see router.go) rather than a
+// linear scan, so lookup time doesn't grow with the number of rules.
+//
 // The only errors that can be returned all related to having invalid YAML data.
 // See MapHandler to create a similar http.HandlerFunc via a mapping of paths to urls.
 
-func YAMLHandler(YAML []byte, fallback http.Handler) (http.HandlerFunc, error) {
+func YAMLHandler(YAML []byte, opts Options, fallback http.Handler) (http.HandlerFunc, error) {
 	var mappers []URLMapper
 	err := yaml.Unmarshal(YAML, &mappers)
 	if err != nil {
 		return nil, err
 	}
-	return func(w http.ResponseWriter, r *http.Request) {
-		for _, mapper := range mappers {
-			if mapper.Path == r.URL.Path {
-				http.Redirect(w, r, mapper.URL, 301)
-				return
-			}
-		}
-		fallback.ServeHTTP(w, r)
-	}, nil
+	return mapperHandler(mappers, opts, fallback)
 }
 
-func JSONHandler(JSON []byte, fallback http.Handler) (http.HandlerFunc, error) {
+func JSONHandler(JSON []byte, opts Options, fallback http.Handler) (http.HandlerFunc, error) {
 	var mappers []URLMapper
 	err := json.Unmarshal(JSON, &mappers)
 	if err != nil {
 		return nil, err
 	}
-	return func(w http.ResponseWriter, r *http.Request) {
-		for _, mapper := range mappers {
-			if mapper.Path == r.URL.Path {
-				http.Redirect(w, r, mapper.URL, 301)
-				return
-			}
-		}
-		fallback.ServeHTTP(w, r)
-	}, nil
+	return mapperHandler(mappers, opts, fallback)
 }
 
 // MapHandler will return an http.HandlerFunc (which also implements http.Handler) that will attempt to map any
 // paths (keys in thge map) to their corresponding URL (values that each key in the map points to, in string format).
 // If the path is not provided in the map, then the fallback http.Handler will be called instead.
-func MapHandler(pathsToUrls map[string]string, fallback http.Handler) http.HandlerFunc {
+func MapHandler(pathsToUrls map[string]string, opts Options, fallback http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		originalURL, ok := pathsToUrls[r.URL.Path]
 		if ok {
-			http.Redirect(w, r, originalURL, 301)
+			http.Redirect(w, r, originalURL, opts.DefaultCode)
 		} else {
 			fallback.ServeHTTP(w, r)
 		}
@@ -82,30 +127,136 @@ func MapHandler(pathsToUrls map[string]string, fallback http.Handler) http.Handl
 
 func main() {
 	config := getConfig()
+	opts := Options{DefaultCode: config.DefaultCode}
 
 	yamlBytes := getFileBytes(config.PathToYAML)
 	jsonBytes := getFileBytes(config.PathToJSON)
 
 	mux := makeDefaultMux()
-	mapHandler := makeMapHandler(mux)
+	mapHandler := makeMapHandler(mux, opts)
 
-	handler := mapHandler
+	var handler http.Handler = mapHandler
 	if yamlBytes != nil {
-		handler = makeYAMLHandler(yamlBytes, &mapHandler)
+		handler = makeYAMLHandler(yamlBytes, opts, &mapHandler)
 	} else if jsonBytes != nil {
-		handler = makeJSONHandler(jsonBytes, &mapHandler)
+		handler = makeJSONHandler(jsonBytes, opts, &mapHandler)
+	}
+
+	if len(config.Sources) > 0 {
+		layered, err := layeredHandler(config.Sources, opts, config.Format, handler)
+		if err != nil {
+			panic(err)
+		}
+		handler = layered
+	}
+
+	var healthReporter HealthReporter
+	if config.Watch != "" {
+		watched, err := WatchingHandler(config.Watch, opts, handler)
+		if err != nil {
+			panic(err)
+		}
+		healthReporter, _ = watched.(HealthReporter)
+		handler = watched
+	}
+
+	store := makeStore(config)
+	if store != nil {
+		handler = StoreHandler(store, opts, handler)
 	}
-	startServer(handler)
+
+	tracker := NewClickTracker(makeClickSink(config), config.ClickBuffer)
+	handler = tracker.Middleware(handler)
+
+	startServer(buildRootHandler(store, tracker, healthReporter, config.ClickSink == "prometheus", handler))
 }
 
 func getConfig() *Config {
 	config := Config{}
 	flag.StringVar(&config.PathToYAML, "yaml", "", "--yaml=path/to/file.yml")
 	flag.StringVar(&config.PathToJSON, "json", "", "--json=path/to/file.json")
+	flag.Var(&config.Sources, "source", "--source=path/to/file.yaml (repeatable; earlier sources take precedence)")
+	flag.StringVar(&config.Format, "format", "", "--format=yaml|json|hcl|toml, used for --source files with no recognized extension")
+	flag.StringVar(&config.Store, "store", "", "--store=memory|bolt|sql, enables the runtime admin API")
+	flag.StringVar(&config.StorePath, "store-path", "urlshort.db", "--store-path=path/to/urlshort.db")
+	flag.StringVar(&config.ClickSink, "click-sink", "", "--click-sink=stdout|sqlite|prometheus, forwards click analytics there in addition to /admin/stats")
+	flag.IntVar(&config.ClickBuffer, "click-buffer", 1024, "--click-buffer=1024, size of the async queue clicks are recorded through before they're dropped")
+	flag.StringVar(&config.Watch, "watch", "", "--watch=path/to/file.yaml, hot-reloads the mapping on every edit instead of parsing it once at boot")
+	flag.IntVar(&config.DefaultCode, "default-code", DefaultOptions().DefaultCode, "--default-code=301|302|307|308, used for any rule that doesn't set its own code")
 	flag.Parse()
 	return &config
 }
 
+// makeClickSink builds the ClickSink requested via --click-sink, or nil
+// if clicks should only feed the in-memory /admin/stats summary.
+func makeClickSink(config *Config) ClickSink {
+	switch config.ClickSink {
+	case "stdout":
+		return NewStdoutSink()
+	case "sqlite":
+		db, err := sql.Open("sqlite3", config.StorePath)
+		if err != nil {
+			panic(err)
+		}
+		sink, err := NewSQLiteSink(db)
+		if err != nil {
+			panic(err)
+		}
+		return sink
+	case "prometheus":
+		return NewPrometheusSink(prometheus.DefaultRegisterer)
+	default:
+		return nil
+	}
+}
+
+// makeStore builds the Store requested via --store, or nil if runtime
+// shortlinks weren't asked for.
+func makeStore(config *Config) Store {
+	switch config.Store {
+	case "memory":
+		return NewMemoryStore()
+	case "bolt":
+		store, err := NewBoltStore(config.StorePath)
+		if err != nil {
+			panic(err)
+		}
+		return store
+	case "sql":
+		db, err := sql.Open("sqlite3", config.StorePath)
+		if err != nil {
+			panic(err)
+		}
+		store, err := NewSQLStore(db)
+		if err != nil {
+			panic(err)
+		}
+		return store
+	default:
+		return nil
+	}
+}
+
+// buildRootHandler mounts the admin REST API (shortlink management when a
+// Store is configured, /admin/stats from tracker, /admin/health when the
+// redirect handler reports its own health, and /metrics when the
+// prometheus click sink is enabled) alongside the redirect handler.
+func buildRootHandler(store Store, tracker *ClickTracker, health HealthReporter, metrics bool, redirectHandler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	if store != nil {
+		mux.Handle("/admin/", AdminHandler(store))
+	}
+	mux.HandleFunc("/admin/stats", tracker.StatsHandler())
+	if health != nil {
+		mux.HandleFunc("/admin/health", health.HealthHandler())
+	}
+	if metrics {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+	mux.Handle("/", redirectHandler)
+	return mux
+}
+
 func getFileBytes(pathToFile string) []byte {
 	bytes, err := ioutil.ReadFile(pathToFile)
 	if err != nil {
@@ -124,30 +275,30 @@ func helloWorldHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "Hello, world!")
 }
 
-func makeMapHandler(mux *http.ServeMux) http.HandlerFunc {
+func makeMapHandler(mux *http.ServeMux, opts Options) http.HandlerFunc {
 	return MapHandler(map[string]string{
 		"/urlshort-godoc": "https://godoc.org/github.com/gophercises/urlshort",
 		"/yaml-godoc":     "https://godoc.org/gopkg.in/yaml.v2",
-	}, mux)
+	}, opts, mux)
 }
 
-func makeYAMLHandler(yamlBytes []byte, fallbackHandler *http.HandlerFunc) http.HandlerFunc {
-	handler, err := YAMLHandler(yamlBytes, fallbackHandler)
+func makeYAMLHandler(yamlBytes []byte, opts Options, fallbackHandler *http.HandlerFunc) http.HandlerFunc {
+	handler, err := YAMLHandler(yamlBytes, opts, fallbackHandler)
 	if err != nil {
 		panic(err)
 	}
 	return handler
 }
 
-func makeJSONHandler(jsonBytes []byte, fallbackHandler *http.HandlerFunc) http.HandlerFunc {
-	handler, err := JSONHandler(jsonBytes, fallbackHandler)
+func makeJSONHandler(jsonBytes []byte, opts Options, fallbackHandler *http.HandlerFunc) http.HandlerFunc {
+	handler, err := JSONHandler(jsonBytes, opts, fallbackHandler)
 	if err != nil {
 		panic(err)
 	}
 	return handler
 }
 
-func startServer(handler http.HandlerFunc) {
+func startServer(handler http.Handler) {
 	fmt.Println("Starting the server on :8080")
 	http.ListenAndServe(":8080", handler)
 }
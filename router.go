@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// routeNode is one segment of the compiled route trie. A request path is
+// matched by walking the trie one "/"-separated segment at a time:
+// static children are preferred, then a single ":param" child, then a
+// "*catchall" child.
+type routeNode struct {
+	children  map[string]*routeNode
+	param     *routeNode
+	paramName string
+	catchAll  *routeNode
+	catchName string
+	mapper    *URLMapper
+	urlTmpl   *template.Template
+	hits      int64 // atomic, counts redirects served so mapper.MaxHits can be enforced
+}
+
+// Router is a compiled trie over URLMapper.Path patterns, built once so
+// that matching a request is O(number of path segments) instead of O(N)
+// over every rule.
+type Router struct {
+	root *routeNode
+}
+
+// NewRouter compiles mappers into a Router. Paths may contain ":name"
+// segments to capture a single path segment, or a trailing "*name"
+// segment to capture the remainder of the path. Mapper URLs may reference
+// captured segments with Go text/template syntax, e.g.
+//
+//	- path: /gh/:user/:repo
+//	  url:  https://github.com/{{.user}}/{{.repo}}
+func NewRouter(mappers []URLMapper) (*Router, error) {
+	root := &routeNode{children: make(map[string]*routeNode)}
+	for i := range mappers {
+		mapper := mappers[i]
+		tmpl, err := template.New(mapper.Path).Parse(mapper.URL)
+		if err != nil {
+			return nil, err
+		}
+		if err := root.insert(mapper.Path, &mapper, tmpl); err != nil {
+			return nil, err
+		}
+	}
+	return &Router{root: root}, nil
+}
+
+func (n *routeNode) insert(path string, mapper *URLMapper, tmpl *template.Template) error {
+	segments := splitPath(path)
+	cur := n
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, "*"):
+			if cur.catchAll == nil {
+				cur.catchAll = &routeNode{children: make(map[string]*routeNode)}
+				cur.catchName = seg[1:]
+			}
+			cur = cur.catchAll
+			if i != len(segments)-1 {
+				continue
+			}
+		case strings.HasPrefix(seg, ":"):
+			if cur.param == nil {
+				cur.param = &routeNode{children: make(map[string]*routeNode)}
+				cur.paramName = seg[1:]
+			}
+			cur = cur.param
+		default:
+			child, ok := cur.children[seg]
+			if !ok {
+				child = &routeNode{children: make(map[string]*routeNode)}
+				cur.children[seg] = child
+			}
+			cur = child
+		}
+	}
+	cur.mapper = mapper
+	cur.urlTmpl = tmpl
+	return nil
+}
+
+// Match walks the trie for path and returns the matching node's
+// URLMapper, its compiled URL template, the captured :param/*catchall
+// values, and whether a match was found. A rule whose Expires has passed
+// or whose MaxHits has been reached is treated as no match, so callers
+// fall through to their fallback handler.
+func (rt *Router) Match(path string) (*URLMapper, *template.Template, map[string]string, bool) {
+	params := map[string]string{}
+	node := rt.root.match(splitPath(path), params)
+	if node == nil {
+		return nil, nil, nil, false
+	}
+	if !node.mapper.Expires.IsZero() && time.Now().After(node.mapper.Expires) {
+		return nil, nil, nil, false
+	}
+	if node.mapper.MaxHits > 0 && atomic.AddInt64(&node.hits, 1) > int64(node.mapper.MaxHits) {
+		return nil, nil, nil, false
+	}
+	return node.mapper, node.urlTmpl, params, true
+}
+
+// match recursively walks segments against n, backtracking to a sibling
+// :param or *catchall branch when a static child leads to a dead end.
+// Without backtracking, overlapping rules like "/files/public" and
+// "/files/*rest" would fail to match "/files/public/x": the static
+// "public" child matches the second segment but has no third-segment
+// child, and the request would wrongly fall through to the fallback
+// handler instead of the catch-all.
+func (n *routeNode) match(segments []string, params map[string]string) *routeNode {
+	if len(segments) == 0 {
+		if n.mapper != nil {
+			return n
+		}
+		return nil
+	}
+	seg, rest := segments[0], segments[1:]
+
+	if child, ok := n.children[seg]; ok {
+		if m := child.match(rest, params); m != nil {
+			return m
+		}
+	}
+	if n.param != nil {
+		prev, had := params[n.paramName]
+		params[n.paramName] = seg
+		if m := n.param.match(rest, params); m != nil {
+			return m
+		}
+		if had {
+			params[n.paramName] = prev
+		} else {
+			delete(params, n.paramName)
+		}
+	}
+	if n.catchAll != nil && n.catchAll.mapper != nil {
+		params[n.catchName] = strings.Join(segments, "/")
+		return n.catchAll
+	}
+	return nil
+}
+
+// findExact walks the trie along the literal segments of a registered
+// route pattern (e.g. "/gh/:user/:repo" -> ["gh", ":user", ":repo"]),
+// as opposed to Match which walks the segments of an incoming request.
+func (n *routeNode) findExact(segments []string) *routeNode {
+	cur := n
+	for _, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, "*"):
+			if cur.catchAll == nil {
+				return nil
+			}
+			cur = cur.catchAll
+		case strings.HasPrefix(seg, ":"):
+			if cur.param == nil {
+				return nil
+			}
+			cur = cur.param
+		default:
+			child, ok := cur.children[seg]
+			if !ok {
+				return nil
+			}
+			cur = child
+		}
+	}
+	return cur
+}
+
+// HitsForPath returns the number of times the rule registered at path has
+// matched, for carrying a MaxHits counter across a WatchingHandler reload.
+func (rt *Router) HitsForPath(path string) int64 {
+	node := rt.root.findExact(splitPath(path))
+	if node == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&node.hits)
+}
+
+// SetHitsForPath seeds the hit counter for the rule registered at path,
+// e.g. to carry a MaxHits counter across a WatchingHandler reload.
+func (rt *Router) SetHitsForPath(path string, hits int64) {
+	node := rt.root.findExact(splitPath(path))
+	if node == nil {
+		return
+	}
+	atomic.StoreInt64(&node.hits, hits)
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+type pathParamsKey struct{}
+
+// PathParams returns the :param/*catchall values captured while matching
+// r's path, for use by handlers downstream of RouterHandler. It returns
+// nil if r wasn't routed through RouterHandler or matched no params.
+func PathParams(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params
+}
+
+// RouterHandler compiles mappers into a Router and returns an
+// http.HandlerFunc that redirects matching requests, expanding any
+// ":param"/"*catchall" captures into the mapper's URL via text/template,
+// and falls back to fallback when nothing matches (including when a
+// matched rule has expired or hit its MaxHits). It replaces the linear
+// scan in YAMLHandler/JSONHandler/mapperHandler with a single trie
+// lookup per request.
+//
+// The redirect status is mapper.Code if set, otherwise opts.DefaultCode;
+// mapper.Headers, if any, are set on the response before the redirect.
+func RouterHandler(mappers []URLMapper, opts Options, fallback http.Handler) (http.HandlerFunc, error) {
+	router, err := NewRouter(mappers)
+	if err != nil {
+		return nil, err
+	}
+	return router.Handler(opts, fallback), nil
+}
+
+// Handler returns an http.HandlerFunc serving rt exactly as RouterHandler
+// does. It's split out from RouterHandler so that callers which need the
+// compiled Router itself (e.g. WatchingHandler, to carry MaxHits counters
+// across a reload) can build it with NewRouter first.
+func (rt *Router) Handler(opts Options, fallback http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mapper, tmpl, params, ok := rt.Match(r.URL.Path)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, params); err != nil {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		for k, v := range mapper.Headers {
+			w.Header().Set(k, v)
+		}
+		code := opts.DefaultCode
+		if mapper.Code != 0 {
+			code = mapper.Code
+		}
+		ctx := context.WithValue(r.Context(), pathParamsKey{}, params)
+		http.Redirect(w, r.WithContext(ctx), buf.String(), code)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRouterStaticMatch(t *testing.T) {
+	mappers := []URLMapper{
+		{Path: "/urlshort-godoc", URL: "https://godoc.org/github.com/gophercises/urlshort"},
+	}
+	router, err := NewRouter(mappers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper, _, params, ok := router.Match("/urlshort-godoc")
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if mapper.URL != mappers[0].URL {
+		t.Errorf("got url %q, want %q", mapper.URL, mappers[0].URL)
+	}
+	if len(params) != 0 {
+		t.Errorf("got params %v, want none", params)
+	}
+	if _, _, _, ok := router.Match("/no-such-path"); ok {
+		t.Error("expected no match for unregistered path")
+	}
+}
+
+func TestRouterParamMatch(t *testing.T) {
+	mappers := []URLMapper{
+		{Path: "/gh/:user/:repo", URL: "https://github.com/{{.user}}/{{.repo}}"},
+	}
+	router, err := NewRouter(mappers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tmpl, params, ok := router.Match("/gh/gophercises/urlshort")
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if params["user"] != "gophercises" || params["repo"] != "urlshort" {
+		t.Errorf("got params %v", params)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "https://github.com/gophercises/urlshort" {
+		t.Errorf("got rendered url %q", got)
+	}
+}
+
+func TestRouterCatchAllMatch(t *testing.T) {
+	mappers := []URLMapper{
+		{Path: "/static/*rest", URL: "https://cdn.example.com/{{.rest}}"},
+	}
+	router, err := NewRouter(mappers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, params, ok := router.Match("/static/css/app.css")
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if params["rest"] != "css/app.css" {
+		t.Errorf("got rest param %q", params["rest"])
+	}
+}
+
+// TestRouterBacktracksPastDeadEndStatic covers overlapping static and
+// catch-all rules: a request that matches a static segment but then
+// dead-ends must fall back to a sibling *catchall rule at the same node
+// rather than reporting no match.
+func TestRouterBacktracksPastDeadEndStatic(t *testing.T) {
+	mappers := []URLMapper{
+		{Path: "/files/public", URL: "https://example.com/public"},
+		{Path: "/files/*rest", URL: "https://cdn.example.com/{{.rest}}"},
+	}
+	router, err := NewRouter(mappers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapper, _, _, ok := router.Match("/files/public")
+	if !ok || mapper.URL != mappers[0].URL {
+		t.Fatalf("got %+v, ok=%v, want the static rule", mapper, ok)
+	}
+
+	mapper, _, params, ok := router.Match("/files/public/x")
+	if !ok {
+		t.Fatal("expected the catch-all rule to match past the dead-end static segment")
+	}
+	if mapper.URL != mappers[1].URL || params["rest"] != "public/x" {
+		t.Errorf("got mapper %+v params %v", mapper, params)
+	}
+}
+
+// linearMatch is the pre-router lookup strategy (see the git history of
+// YAMLHandler/JSONHandler): a plain scan over every rule. It's kept here,
+// unexported to this test file, purely as a baseline for BenchmarkRouter.
+func linearMatch(mappers []URLMapper, path string) (*URLMapper, bool) {
+	for i := range mappers {
+		if mappers[i].Path == path {
+			return &mappers[i], true
+		}
+	}
+	return nil, false
+}
+
+func tenThousandRoutes() []URLMapper {
+	mappers := make([]URLMapper, 10000)
+	for i := range mappers {
+		mappers[i] = URLMapper{
+			Path: fmt.Sprintf("/route-%d", i),
+			URL:  fmt.Sprintf("https://example.com/%d", i),
+		}
+	}
+	return mappers
+}
+
+func BenchmarkRouterMatch(b *testing.B) {
+	mappers := tenThousandRoutes()
+	router, err := NewRouter(mappers)
+	if err != nil {
+		b.Fatal(err)
+	}
+	path := mappers[len(mappers)-1].Path
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, ok := router.Match(path); !ok {
+			b.Fatal("expected a match")
+		}
+	}
+}
+
+func BenchmarkLinearScan(b *testing.B) {
+	mappers := tenThousandRoutes()
+	path := mappers[len(mappers)-1].Path
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := linearMatch(mappers, path); !ok {
+			b.Fatal("expected a match")
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// Store is the interface implemented by anything that can persist and
+// serve up path -> url shortlinks at runtime. It lets the shortener grow
+// beyond a one-shot static file into something that can be updated while
+// the server is running.
+type Store interface {
+	// Get looks up the URL mapped to path. ok is false if no mapping exists.
+	Get(path string) (url string, ok bool)
+	// Put creates or overwrites the mapping for path.
+	Put(path, url string) error
+	// Delete removes the mapping for path, if any.
+	Delete(path string) error
+	// List returns every mapping currently known to the store.
+	List() ([]URLMapper, error)
+}
+
+// MemoryStore is a Store backed by an in-memory map. Mappings do not
+// survive a restart; it's mainly useful for tests and for layering on
+// top of a static file as a scratch space for runtime-created links.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	urls map[string]string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{urls: make(map[string]string)}
+}
+
+func (s *MemoryStore) Get(path string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	url, ok := s.urls[path]
+	return url, ok
+}
+
+func (s *MemoryStore) Put(path, url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.urls[path] = url
+	return nil
+}
+
+func (s *MemoryStore) Delete(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.urls, path)
+	return nil
+}
+
+func (s *MemoryStore) List() ([]URLMapper, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	mappers := make([]URLMapper, 0, len(s.urls))
+	for path, url := range s.urls {
+		mappers = append(mappers, URLMapper{Path: path, URL: url})
+	}
+	return mappers, nil
+}
+
+// boltBucket is the bucket BoltStore keeps all of its mappings in.
+var boltBucket = []byte("urls")
+
+// BoltStore is a Store backed by a BoltDB file, giving persistence
+// across restarts without needing a separate database server.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) the BoltDB file at path and
+// returns a Store backed by it.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(path string) (string, bool) {
+	var url string
+	var ok bool
+	s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(path))
+		if v != nil {
+			url, ok = string(v), true
+		}
+		return nil
+	})
+	return url, ok
+}
+
+func (s *BoltStore) Put(path, url string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(path), []byte(url))
+	})
+}
+
+func (s *BoltStore) Delete(path string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(path))
+	})
+}
+
+func (s *BoltStore) List() ([]URLMapper, error) {
+	var mappers []URLMapper
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			mappers = append(mappers, URLMapper{Path: string(k), URL: string(v)})
+			return nil
+		})
+	})
+	return mappers, err
+}
+
+// SQLStore is a Store backed by any database/sql driver, e.g. sqlite3,
+// postgres or mysql. The caller is responsible for opening db with the
+// driver of their choice; SQLStore only assumes a "shortlinks" table.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore creates the shortlinks table if it doesn't already exist
+// and returns a Store backed by db.
+func NewSQLStore(db *sql.DB) (*SQLStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS shortlinks (
+		path TEXT PRIMARY KEY,
+		url  TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Get(path string) (string, bool) {
+	var url string
+	err := s.db.QueryRow(`SELECT url FROM shortlinks WHERE path = ?`, path).Scan(&url)
+	if err != nil {
+		return "", false
+	}
+	return url, true
+}
+
+func (s *SQLStore) Put(path, url string) error {
+	_, err := s.db.Exec(`INSERT INTO shortlinks (path, url) VALUES (?, ?)
+		ON CONFLICT(path) DO UPDATE SET url = excluded.url`, path, url)
+	return err
+}
+
+func (s *SQLStore) Delete(path string) error {
+	_, err := s.db.Exec(`DELETE FROM shortlinks WHERE path = ?`, path)
+	return err
+}
+
+func (s *SQLStore) List() ([]URLMapper, error) {
+	rows, err := s.db.Query(`SELECT path, url FROM shortlinks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappers []URLMapper
+	for rows.Next() {
+		var m URLMapper
+		if err := rows.Scan(&m.Path, &m.URL); err != nil {
+			return nil, err
+		}
+		mappers = append(mappers, m)
+	}
+	return mappers, rows.Err()
+}
+
+// StoreHandler returns an http.HandlerFunc that looks paths up in store
+// and redirects to the mapped URL using opts.DefaultCode. If the path
+// isn't known to store, the fallback http.Handler is called instead,
+// mirroring YAMLHandler and JSONHandler.
+func StoreHandler(store Store, opts Options, fallback http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if url, ok := store.Get(r.URL.Path); ok {
+			http.Redirect(w, r, url, opts.DefaultCode)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	}
+}
+
+// shortenRequest is the JSON body expected by POST /admin/shorten.
+type shortenRequest struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+}
+
+// AdminHandler returns an http.Handler exposing REST endpoints for
+// managing shortlinks in store at runtime:
+//
+//	POST   /admin/shorten   create or update a mapping
+//	DELETE /admin/{path}    remove a mapping
+//	GET    /admin/list      list every mapping
+func AdminHandler(store Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/shorten", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req shortenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Path == "" || req.URL == "" {
+			http.Error(w, "path and url are required", http.StatusBadRequest)
+			return
+		}
+		if err := store.Put(req.Path, req.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(URLMapper{Path: req.Path, URL: req.URL})
+	})
+	mux.HandleFunc("/admin/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		mappers, err := store.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(mappers)
+	})
+	mux.HandleFunc("/admin/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		path := "/" + r.URL.Path[len("/admin/"):]
+		if err := store.Delete(path); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchingHandler wraps a source file with fsnotify: edits are re-parsed
+// and swapped in atomically via atomic.Value, so in-flight requests are
+// never served a half-updated mapping and the process never needs
+// restarting to pick up a changed file. If a reload fails to parse, the
+// last-known-good mapping keeps serving and the error is recorded for
+// HealthHandler.
+type watchingHandler struct {
+	path     string
+	opts     Options
+	fallback http.Handler
+
+	current    atomic.Value // http.HandlerFunc
+	router     atomic.Value // *Router, the live router current was built from
+	lastErr    atomic.Value // string, empty when healthy
+	lastReload atomic.Value // time.Time
+}
+
+// HealthReporter is implemented by handlers that can report whether
+// they're currently serving a good mapping, for mounting at /admin/health.
+type HealthReporter interface {
+	HealthHandler() http.HandlerFunc
+}
+
+// WatchingHandler loads path, starts watching it (via fsnotify) for
+// changes, and returns an http.Handler that redirects matching requests
+// and falls back to fallback otherwise, just like FileHandler. Unlike
+// FileHandler, edits to path are picked up live: the handler re-parses on
+// change and atomically swaps in the new mapping. The initial load must
+// succeed; the returned error means path doesn't exist or doesn't parse.
+// The returned handler also implements HealthReporter.
+func WatchingHandler(path string, opts Options, fallback http.Handler) (http.Handler, error) {
+	wh := &watchingHandler{path: path, opts: opts, fallback: fallback}
+	if err := wh.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go wh.watch(watcher)
+
+	return wh, nil
+}
+
+func (wh *watchingHandler) watch(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(wh.path) {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			wh.reload()
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func (wh *watchingHandler) reload() error {
+	decoder, ok := formatDecoders[filepath.Ext(wh.path)]
+	if !ok {
+		err := fmt.Errorf("urlshort: no decoder registered for extension %q", filepath.Ext(wh.path))
+		wh.lastErr.Store(err.Error())
+		return err
+	}
+	data, err := ioutil.ReadFile(wh.path)
+	if err != nil {
+		wh.lastErr.Store(err.Error())
+		return err
+	}
+	var mappers []URLMapper
+	if err := decoder(data, &mappers); err != nil {
+		wh.lastErr.Store(err.Error())
+		return err
+	}
+	router, err := NewRouter(mappers)
+	if err != nil {
+		wh.lastErr.Store(err.Error())
+		return err
+	}
+
+	// Seed the new router's MaxHits counters from the router actually
+	// serving traffic until now, not from the brand-new one (which has
+	// seen zero requests). The very first reload has no prior router.
+	if prev, ok := wh.router.Load().(*Router); ok {
+		for _, mapper := range mappers {
+			if mapper.MaxHits > 0 {
+				router.SetHitsForPath(mapper.Path, prev.HitsForPath(mapper.Path))
+			}
+		}
+	}
+
+	wh.router.Store(router)
+	wh.current.Store(router.Handler(wh.opts, wh.fallback))
+	wh.lastErr.Store("")
+	wh.lastReload.Store(time.Now())
+	return nil
+}
+
+func (wh *watchingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wh.current.Load().(http.HandlerFunc)(w, r)
+}
+
+// healthStatus is the JSON body served by HealthHandler.
+type healthStatus struct {
+	Healthy    bool      `json:"healthy"`
+	Error      string    `json:"error,omitempty"`
+	LastReload time.Time `json:"last_reload"`
+}
+
+// HealthHandler reports whether the most recent reload of wh's source
+// succeeded, satisfying HealthReporter for mounting at /admin/health.
+func (wh *watchingHandler) HealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lastErr, _ := wh.lastErr.Load().(string)
+		status := healthStatus{
+			Healthy: lastErr == "",
+			Error:   lastErr,
+		}
+		if t, ok := wh.lastReload.Load().(time.Time); ok {
+			status.LastReload = t
+		}
+		if !status.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	}
+}